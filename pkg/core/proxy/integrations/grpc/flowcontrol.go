@@ -0,0 +1,178 @@
+//go:build linux
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// defaultInitialWindowSize and defaultMaxFrameSize are the HTTP/2 protocol
+// defaults (RFC 7540 §6.5.2) assumed until SETTINGS says otherwise.
+const (
+	defaultInitialWindowSize = 65535
+	defaultMaxFrameSize      = 16384
+)
+
+// flowController is an HTTP/2 flow-control accountant for DATA frames
+// travelling in one direction of a proxied gRPC connection (see
+// connFlowState). It is seeded from SETTINGS_INITIAL_WINDOW_SIZE /
+// SETTINGS_MAX_FRAME_SIZE and updated by WINDOW_UPDATE frames observed on
+// the *opposite* transferFrame call, since the side advertising a window or
+// a max frame size is the receiver constraining what is sent to it.
+type flowController struct {
+	mu                  sync.Mutex
+	cond                *sync.Cond
+	connWindow          int64
+	streamWindows       map[uint32]int64
+	initialStreamWindow int64
+	maxFrameSize        uint32
+}
+
+func newFlowController() *flowController {
+	fc := &flowController{
+		connWindow:          defaultInitialWindowSize,
+		streamWindows:       make(map[uint32]int64),
+		initialStreamWindow: defaultInitialWindowSize,
+		maxFrameSize:        defaultMaxFrameSize,
+	}
+	fc.cond = sync.NewCond(&fc.mu)
+	return fc
+}
+
+// applySetting updates the accountant from an observed SETTINGS value.
+func (fc *flowController) applySetting(id http2.SettingID, val uint32) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	switch id {
+	case http2.SettingInitialWindowSize:
+		// RFC 7540 §6.9.2: existing stream windows move by the delta, not to
+		// the new value outright.
+		delta := int64(val) - fc.initialStreamWindow
+		fc.initialStreamWindow = int64(val)
+		for streamID := range fc.streamWindows {
+			fc.streamWindows[streamID] += delta
+		}
+		fc.cond.Broadcast()
+	case http2.SettingMaxFrameSize:
+		fc.maxFrameSize = val
+	}
+}
+
+// windowUpdate applies an observed WINDOW_UPDATE to the connection window
+// (streamID == 0) or to a single stream's window.
+func (fc *flowController) windowUpdate(streamID uint32, increment uint32) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if streamID == 0 {
+		fc.connWindow += int64(increment)
+	} else {
+		fc.streamWindows[streamID] = fc.streamWindowLocked(streamID) + int64(increment)
+	}
+	fc.cond.Broadcast()
+}
+
+// streamWindowLocked returns a stream's current window, seeding it from the
+// negotiated initial size on first use. The caller must hold fc.mu.
+func (fc *flowController) streamWindowLocked(streamID uint32) int64 {
+	win, ok := fc.streamWindows[streamID]
+	if !ok {
+		win = fc.initialStreamWindow
+		fc.streamWindows[streamID] = win
+	}
+	return win
+}
+
+// acquire blocks until at least one byte of window is available for
+// streamID, then reserves and returns min(want, maxFrameSize, connWindow,
+// streamWindow). It returns early with ctx.Err() if ctx is cancelled first.
+func (fc *flowController) acquire(ctx context.Context, streamID uint32, want int) (int, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			fc.mu.Lock()
+			fc.cond.Broadcast()
+			fc.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		chunk := want
+		if chunk > int(fc.maxFrameSize) {
+			chunk = int(fc.maxFrameSize)
+		}
+		if int64(chunk) > fc.connWindow {
+			chunk = int(fc.connWindow)
+		}
+		if streamWin := fc.streamWindowLocked(streamID); int64(chunk) > streamWin {
+			chunk = int(streamWin)
+		}
+		if chunk > 0 {
+			fc.connWindow -= int64(chunk)
+			fc.streamWindows[streamID] -= int64(chunk)
+			return chunk, nil
+		}
+
+		fc.cond.Wait()
+	}
+}
+
+// closeStream releases a finished stream's window bookkeeping.
+func (fc *flowController) closeStream(streamID uint32) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	delete(fc.streamWindows, streamID)
+}
+
+// connFlowState holds the two directional flow-control accountants for one
+// proxied connection pair, shared between the client->server and
+// server->client transferFrame goroutines.
+type connFlowState struct {
+	toServer *flowController
+	toClient *flowController
+}
+
+func newConnFlowState() *connFlowState {
+	return &connFlowState{
+		toServer: newFlowController(),
+		toClient: newFlowController(),
+	}
+}
+
+// writeDataWithFlowControl chunks payload to min(peer max frame size, stream
+// window, connection window) before writing each chunk, blocking until the
+// window opens, and sets EndStream only on the final chunk.
+func writeDataWithFlowControl(ctx context.Context, framer *http2.Framer, fc *flowController, streamID uint32, payload []byte, endStream bool) error {
+	if len(payload) == 0 {
+		if err := framer.WriteData(streamID, endStream, nil); err != nil {
+			return fmt.Errorf("could not write data frame: %v", err)
+		}
+		return nil
+	}
+
+	for len(payload) > 0 {
+		n, err := fc.acquire(ctx, streamID, len(payload))
+		if err != nil {
+			return fmt.Errorf("flow control wait interrupted: %w", err)
+		}
+		chunk := payload[:n]
+		payload = payload[n:]
+		if err := framer.WriteData(streamID, endStream && len(payload) == 0, chunk); err != nil {
+			return fmt.Errorf("could not write data frame: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+//go:build linux
+
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// TestFlowController_AcquireCapsToWant confirms acquire never hands out more
+// than requested, even when both windows have ample room.
+func TestFlowController_AcquireCapsToWant(t *testing.T) {
+	fc := newFlowController()
+
+	n, err := fc.acquire(context.Background(), 1, 100)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	if n != 100 {
+		t.Fatalf("acquire returned %d, want 100", n)
+	}
+}
+
+// TestFlowController_AcquireCapsToMaxFrameSize confirms a want larger than
+// maxFrameSize is chunked down to it, per RFC 7540 SETTINGS_MAX_FRAME_SIZE.
+func TestFlowController_AcquireCapsToMaxFrameSize(t *testing.T) {
+	fc := newFlowController()
+	fc.applySetting(http2.SettingMaxFrameSize, 10)
+
+	n, err := fc.acquire(context.Background(), 1, 100)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	if n != 10 {
+		t.Fatalf("acquire returned %d, want 10", n)
+	}
+}
+
+// TestFlowController_AcquireBlocksUntilWindowUpdate confirms acquire blocks
+// while a stream's window is exhausted and unblocks as soon as a
+// WINDOW_UPDATE arrives, returning exactly the credit that update granted.
+func TestFlowController_AcquireBlocksUntilWindowUpdate(t *testing.T) {
+	fc := newFlowController()
+
+	// Drain the stream's entire initial window so the next acquire call has
+	// to block. acquire grants at most maxFrameSize per call, so this takes
+	// several calls.
+	for drained := 0; drained < defaultInitialWindowSize; {
+		n, err := fc.acquire(context.Background(), 1, defaultInitialWindowSize-drained)
+		if err != nil {
+			t.Fatalf("drain acquire failed: %v", err)
+		}
+		drained += n
+	}
+	// Draining stream 1 spent the connection window too; reopen it so only
+	// the stream window gates the next acquire.
+	fc.windowUpdate(0, defaultInitialWindowSize)
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := fc.acquire(context.Background(), 1, 100)
+		done <- result{n, err}
+	}()
+
+	select {
+	case r := <-done:
+		t.Fatalf("acquire returned (%d, %v) before any WINDOW_UPDATE was applied, want it to block", r.n, r.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fc.windowUpdate(1, 30)
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("acquire failed after WINDOW_UPDATE: %v", r.err)
+		}
+		if r.n != 30 {
+			t.Fatalf("acquire returned %d after a 30-byte WINDOW_UPDATE, want 30", r.n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire did not unblock within 1s of the WINDOW_UPDATE")
+	}
+}
+
+// TestFlowController_AcquireUnblocksOnContextCancel confirms a blocked
+// acquire returns ctx.Err() promptly instead of hanging forever when no
+// WINDOW_UPDATE ever arrives — the failure mode that would otherwise wedge
+// the whole proxy.
+func TestFlowController_AcquireUnblocksOnContextCancel(t *testing.T) {
+	fc := newFlowController()
+	for drained := 0; drained < defaultInitialWindowSize; {
+		n, err := fc.acquire(context.Background(), 1, defaultInitialWindowSize-drained)
+		if err != nil {
+			t.Fatalf("drain acquire failed: %v", err)
+		}
+		drained += n
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := fc.acquire(ctx, 1, 100)
+		done <- result{n, err}
+	}()
+
+	select {
+	case r := <-done:
+		t.Fatalf("acquire returned (%d, %v) before cancellation, want it to block", r.n, r.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+
+	select {
+	case r := <-done:
+		if r.err == nil {
+			t.Fatal("acquire returned a nil error after ctx cancellation, want ctx.Err()")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire did not unblock within 1s of ctx cancellation")
+	}
+}
+
+// TestFlowController_ConnWindowGatesAcrossStreams confirms the connection
+// window is shared: exhausting it via one stream blocks acquire on another
+// stream until a connection-level (streamID 0) WINDOW_UPDATE arrives.
+func TestFlowController_ConnWindowGatesAcrossStreams(t *testing.T) {
+	fc := newFlowController()
+
+	for drained := 0; drained < defaultInitialWindowSize; {
+		n, err := fc.acquire(context.Background(), 1, defaultInitialWindowSize-drained)
+		if err != nil {
+			t.Fatalf("drain acquire on stream 1 failed: %v", err)
+		}
+		drained += n
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := fc.acquire(context.Background(), 2, 100)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("acquire on stream 2 returned (err=%v) before the conn window was topped up, want it to block", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fc.windowUpdate(0, 100)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("acquire on stream 2 failed after a conn-level WINDOW_UPDATE: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire on stream 2 did not unblock within 1s of the conn-level WINDOW_UPDATE")
+	}
+}
+
+// TestFlowController_ApplySettingMovesExistingWindowsByDelta confirms a
+// SETTINGS_INITIAL_WINDOW_SIZE change shifts an already-negotiated stream's
+// remaining window by the delta rather than resetting it outright, per RFC
+// 7540 §6.9.2.
+func TestFlowController_ApplySettingMovesExistingWindowsByDelta(t *testing.T) {
+	fc := newFlowController()
+
+	// Seed stream 1's window and spend part of it (acquire grants at most
+	// maxFrameSize of the requested half).
+	spent, err := fc.acquire(context.Background(), 1, defaultInitialWindowSize/2)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	fc.applySetting(http2.SettingInitialWindowSize, defaultInitialWindowSize*2)
+
+	fc.mu.Lock()
+	got := fc.streamWindows[1]
+	fc.mu.Unlock()
+
+	want := int64(defaultInitialWindowSize-spent) + int64(defaultInitialWindowSize)
+	if got != want {
+		t.Fatalf("stream window after resize = %d, want %d", got, want)
+	}
+}
+
+// TestFlowController_CloseStreamDropsOnlyThatStream confirms closeStream
+// releases bookkeeping for the given stream without disturbing another
+// stream's window — the invariant the server-streaming StreamEnded fix in
+// frame.go depends on.
+func TestFlowController_CloseStreamDropsOnlyThatStream(t *testing.T) {
+	fc := newFlowController()
+	fc.windowUpdate(1, 10)
+	fc.windowUpdate(2, 20)
+
+	fc.closeStream(1)
+
+	fc.mu.Lock()
+	_, stillHasStream1 := fc.streamWindows[1]
+	stream2Window := fc.streamWindows[2]
+	fc.mu.Unlock()
+
+	if stillHasStream1 {
+		t.Error("closeStream(1) left stream 1's window bookkeeping behind")
+	}
+	if stream2Window != defaultInitialWindowSize+20 {
+		t.Errorf("stream 2's window = %d after closing stream 1, want untouched at %d", stream2Window, defaultInitialWindowSize+20)
+	}
+}
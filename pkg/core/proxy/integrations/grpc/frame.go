@@ -17,9 +17,34 @@ import (
 )
 
 // transferFrame reads one frame from rhs and writes it to lhs.
-func transferFrame(ctx context.Context, _ *zap.Logger, lhs net.Conn, rhs net.Conn, sic *StreamInfoCollection, reqFromClient bool, decoder *hpack.Decoder, mocks chan<- *models.Mock) error {
+func transferFrame(ctx context.Context, logger *zap.Logger, lhs net.Conn, rhs net.Conn, sic *StreamInfoCollection, reqFromClient bool, decoder *hpack.Decoder, mocks chan<- *models.Mock, tableSizeSync *hpackTableSizeSync, flow *connFlowState) error {
 	respFromServer := !reqFromClient
 	framer := http2.NewFramer(lhs, rhs)
+	// Until a SETTINGS_HEADER_TABLE_SIZE is negotiated, HPACK uses the
+	// protocol default dynamic table size.
+	sic.SetHeaderTableSize(reqFromClient, KmaxDynamicTableSize)
+
+	// Frames read on this call are sent by the peer on the opposite side of
+	// the DATA flow they govern: SETTINGS/WINDOW_UPDATE observed here
+	// constrain what the proxy may send in the *other* direction, while
+	// DATA written by this call is itself gated by the controller for this
+	// direction.
+	inboundUpdates, outbound := flow.toServer, flow.toClient
+	if reqFromClient {
+		inboundUpdates, outbound = flow.toClient, flow.toServer
+	}
+	// headerBlocks buffers HEADERS + CONTINUATION fragments per stream until
+	// HeadersEnded() is true, since hpack cannot decode a partial block without
+	// corrupting the shared dynamic table (RFC 7540 §6.10).
+	headerBlocks := make(map[uint32]*headerBlockBuffer)
+	// inProgressStream is non-zero while a header block on that stream is
+	// awaiting its closing CONTINUATION; per the spec no other frame may be
+	// interleaved on the connection until then.
+	var inProgressStream uint32
+	// reassemblers and streamCodecs track, per stream, the in-progress gRPC
+	// Length-Prefixed-Message buffer and the grpc-encoding advertised for it.
+	reassemblers := make(map[uint32]*lpmReassembler)
+	streamCodecs := make(map[uint32]string)
 	for {
 		select {
 		case <-ctx.Done():
@@ -33,10 +58,24 @@ func transferFrame(ctx context.Context, _ *zap.Logger, lhs net.Conn, rhs net.Con
 				return fmt.Errorf("error reading frame %v", err)
 			}
 
+			if inProgressStream != 0 {
+				if _, ok := frame.(*http2.ContinuationFrame); !ok {
+					logger.Error("protocol violation: frame interleaved while a header block is in progress",
+						zap.Uint32("streamID", inProgressStream), zap.String("http2Error", http2.ErrCodeProtocol.String()))
+				}
+			}
+
 			switch frame := frame.(type) {
 			case *http2.SettingsFrame:
 				settingsFrame := frame
 				if settingsFrame.IsAck() {
+					// The peer's SETTINGS have now taken effect: apply any
+					// SETTINGS_HEADER_TABLE_SIZE that was pending on our own
+					// decoder before relaying the ACK.
+					if size, ok := tableSizeSync.takeAck(reqFromClient); ok {
+						decoder.SetAllowedMaxDynamicTableSize(size)
+						sic.SetHeaderTableSize(reqFromClient, size)
+					}
 					// Transfer Ack.
 					if err := framer.WriteSettingsAck(); err != nil {
 						return fmt.Errorf("could not write ack for settings frame: %v", err)
@@ -45,6 +84,15 @@ func transferFrame(ctx context.Context, _ *zap.Logger, lhs net.Conn, rhs net.Con
 					var settingsCollection []http2.Setting
 					err = settingsFrame.ForeachSetting(func(setting http2.Setting) error {
 						settingsCollection = append(settingsCollection, setting)
+						if setting.ID == http2.SettingHeaderTableSize {
+							// The new size must not be applied until the
+							// sender's ACK is observed on the opposite
+							// transferFrame direction (RFC 7541 §4.2).
+							tableSizeSync.propose(reqFromClient, setting.Val)
+						}
+						if setting.ID == http2.SettingInitialWindowSize || setting.ID == http2.SettingMaxFrameSize {
+							inboundUpdates.applySetting(setting.ID, setting.Val)
+						}
 						return nil
 					})
 					if err != nil {
@@ -69,58 +117,102 @@ func transferFrame(ctx context.Context, _ *zap.Logger, lhs net.Conn, rhs net.Con
 				if err != nil {
 					return fmt.Errorf("could not write headers frame: %v", err)
 				}
-				pseudoHeaders, ordinaryHeaders, err := extractHeaders(headersFrame, decoder)
-				if err != nil {
-					return fmt.Errorf("could not extract headers from frame: %v", err)
+
+				headerBlocks[streamID] = &headerBlockBuffer{streamEnded: headersFrame.StreamEnded()}
+				headerBlocks[streamID].fragment = append(headerBlocks[streamID].fragment, headersFrame.HeaderBlockFragment()...)
+				if headersFrame.HeadersEnded() {
+					block := headerBlocks[streamID]
+					delete(headerBlocks, streamID)
+					if err := processHeaderBlock(ctx, logger, streamID, block, decoder, sic, reqFromClient, respFromServer, mocks, streamCodecs); err != nil {
+						return err
+					}
+				} else {
+					inProgressStream = streamID
 				}
 
-				if reqFromClient {
-					sic.AddHeadersForRequest(streamID, pseudoHeaders, true)
-					sic.AddHeadersForRequest(streamID, ordinaryHeaders, false)
+			case *http2.ContinuationFrame:
+				continuationFrame := frame
+				streamID := continuationFrame.StreamID
+				err := framer.WriteContinuation(streamID, continuationFrame.HeadersEnded(),
+					continuationFrame.HeaderBlockFragment())
+				if err != nil {
+					return fmt.Errorf("could not write continuation frame: %v", err)
+				}
 
-				} else if respFromServer {
-					if headersFrame.StreamEnded() {
-						// Trailers — filter grpc-* as trailer, rest as normal headers
-						pseudoNormal, pseudoTrailer := splitGrpcTrailerHeaders(pseudoHeaders)
-						ordinaryNormal, ordinaryTrailer := splitGrpcTrailerHeaders(ordinaryHeaders)
-
-						// Add "normal" parts as headers (still appears in trailers, but your system might need this distinction)
-						sic.AddHeadersForResponse(streamID, pseudoNormal, true, false)
-						sic.AddHeadersForResponse(streamID, ordinaryNormal, false, false)
-
-						// Add "grpc-" keys as actual trailers
-						sic.AddHeadersForResponse(streamID, pseudoTrailer, true, true)
-						sic.AddHeadersForResponse(streamID, ordinaryTrailer, false, true)
-
-					} else {
-						// Just regular headers
-						sic.AddHeadersForResponse(streamID, pseudoHeaders, true, false)
-						sic.AddHeadersForResponse(streamID, ordinaryHeaders, false, false)
-					}
+				block, ok := headerBlocks[streamID]
+				if !ok {
+					logger.Error("protocol violation: CONTINUATION received with no preceding HEADERS",
+						zap.Uint32("streamID", streamID), zap.String("http2Error", http2.ErrCodeProtocol.String()))
+					continue
 				}
-				// The trailers frame has been received. The stream has been closed by the server.
-				// Capture the mock and clear the map, as the stream ID can be reused by client.
-				if respFromServer && headersFrame.StreamEnded() {
-					sic.PersistMockForStream(ctx, streamID, mocks)
-					sic.ResetStream(streamID)
+				block.fragment = append(block.fragment, continuationFrame.HeaderBlockFragment()...)
+				if continuationFrame.HeadersEnded() {
+					delete(headerBlocks, streamID)
+					inProgressStream = 0
+					if err := processHeaderBlock(ctx, logger, streamID, block, decoder, sic, reqFromClient, respFromServer, mocks, streamCodecs); err != nil {
+						return err
+					}
 				}
 
 			case *http2.DataFrame:
 				dataFrame := frame
-				err := framer.WriteData(dataFrame.StreamID, dataFrame.StreamEnded(), dataFrame.Data())
+				if err := writeDataWithFlowControl(ctx, framer, outbound, dataFrame.StreamID, dataFrame.Data(), dataFrame.StreamEnded()); err != nil {
+					return err
+				}
+
+				reassembler, ok := reassemblers[dataFrame.StreamID]
+				if !ok {
+					reassembler = &lpmReassembler{}
+					reassemblers[dataFrame.StreamID] = reassembler
+				}
+				messages, err := reassembler.feed(dataFrame.Data(), streamCodecs[dataFrame.StreamID])
 				if err != nil {
-					return fmt.Errorf("could not write data frame: %v", err)
+					logger.Error("could not reassemble grpc message",
+						zap.Uint32("streamID", dataFrame.StreamID), zap.Error(err))
 				}
+
 				if reqFromClient {
 					// Capturing the request timestamp
 					sic.ReqTimestampMock = time.Now()
 
-					sic.AddPayloadForRequest(dataFrame.StreamID, dataFrame.Data())
+					for _, message := range messages {
+						if message.DecodeFailed {
+							// Payload is still the raw, on-wire compressed
+							// bytes: persisting it would let replay run it
+							// back through the compressor a second time and
+							// write a corrupt message, so this message is
+							// excluded from the captured mock entirely.
+							logger.Error("dropping undecodable grpc request message from captured mock",
+								zap.Uint32("streamID", dataFrame.StreamID), zap.String("encoding", message.Encoding))
+							continue
+						}
+						sic.AddPayloadForRequest(dataFrame.StreamID, message.Payload, message.Compressed, message.Encoding)
+					}
 				} else if respFromServer {
 					// Capturing the response timestamp
 					sic.ResTimestampMock = time.Now()
 
-					sic.AddPayloadForResponse(dataFrame.StreamID, dataFrame.Data())
+					for _, message := range messages {
+						if message.DecodeFailed {
+							logger.Error("dropping undecodable grpc response message from captured mock",
+								zap.Uint32("streamID", dataFrame.StreamID), zap.String("encoding", message.Encoding))
+							continue
+						}
+						sic.AddPayloadForResponse(dataFrame.StreamID, message.Payload, message.Compressed, message.Encoding)
+					}
+				}
+
+				if dataFrame.StreamEnded() {
+					delete(reassemblers, dataFrame.StreamID)
+					delete(streamCodecs, dataFrame.StreamID)
+					// Only this call's own outbound direction just ended: in
+					// the common server-streaming case the client's single
+					// request DATA frame (END_STREAM) completes long before
+					// the server's multi-chunk response does, so closing the
+					// other direction's controller here would wipe out a
+					// still-active stream window (including any WINDOW_UPDATE
+					// already granted proactively for it).
+					outbound.closeStream(dataFrame.StreamID)
 				}
 			case *http2.PingFrame:
 				pingFrame := frame
@@ -134,13 +226,7 @@ func transferFrame(ctx context.Context, _ *zap.Logger, lhs net.Conn, rhs net.Con
 				if err != nil {
 					return fmt.Errorf("could not write window tools frame: %v", err)
 				}
-			case *http2.ContinuationFrame:
-				continuationFrame := frame
-				err := framer.WriteContinuation(continuationFrame.StreamID, continuationFrame.HeadersEnded(),
-					continuationFrame.HeaderBlockFragment())
-				if err != nil {
-					return fmt.Errorf("could not write continuation frame: %v", err)
-				}
+				inboundUpdates.windowUpdate(windowUpdateFrame.StreamID, windowUpdateFrame.Increment)
 			case *http2.PriorityFrame:
 				priorityFrame := frame
 				err := framer.WritePriority(priorityFrame.StreamID, priorityFrame.PriorityParam)
@@ -189,13 +275,24 @@ func splitGrpcTrailerHeaders(headers map[string]string) (normal map[string]strin
 	return
 }
 
-// constants for dynamic table size
+// KmaxDynamicTableSize is the HPACK dynamic table size (RFC 7541 §6.5.2)
+// assumed for both directions of a connection until a SETTINGS_HEADER_TABLE_SIZE
+// is negotiated and ACKed.
 const (
 	KmaxDynamicTableSize = 4096
 )
 
-func extractHeaders(frame *http2.HeadersFrame, decoder *hpack.Decoder) (pseudoHeaders, ordinaryHeaders map[string]string, err error) {
-	hf, err := decoder.DecodeFull(frame.HeaderBlockFragment())
+// headerBlockBuffer accumulates the HEADERS fragment and any following
+// CONTINUATION fragments for a single stream until HeadersEnded() is true.
+type headerBlockBuffer struct {
+	fragment    []byte
+	streamEnded bool
+}
+
+// extractHeaders decodes a complete header block, built from a HEADERS frame
+// and zero or more CONTINUATION frames, into pseudo and ordinary header maps.
+func extractHeaders(block []byte, decoder *hpack.Decoder) (pseudoHeaders, ordinaryHeaders map[string]string, err error) {
+	hf, err := decoder.DecodeFull(block)
 	if err != nil {
 		return nil, nil, fmt.Errorf("could not decode headers: %v", err)
 	}
@@ -213,3 +310,61 @@ func extractHeaders(frame *http2.HeadersFrame, decoder *hpack.Decoder) (pseudoHe
 
 	return pseudoHeaders, ordinaryHeaders, nil
 }
+
+// processHeaderBlock decodes a fully reassembled header block and drives
+// StreamInfoCollection from it. It also records the stream's grpc-encoding
+// and, for a stream-ending response block, parses the grpc status trailers.
+func processHeaderBlock(ctx context.Context, logger *zap.Logger, streamID uint32, block *headerBlockBuffer, decoder *hpack.Decoder, sic *StreamInfoCollection, reqFromClient, respFromServer bool, mocks chan<- *models.Mock, streamCodecs map[uint32]string) error {
+	pseudoHeaders, ordinaryHeaders, err := extractHeaders(block.fragment, decoder)
+	if err != nil {
+		return fmt.Errorf("could not extract headers from frame: %v", err)
+	}
+
+	if encoding, ok := ordinaryHeaders["grpc-encoding"]; ok {
+		streamCodecs[streamID] = encoding
+	}
+
+	if reqFromClient {
+		sic.AddHeadersForRequest(streamID, pseudoHeaders, true)
+		sic.AddHeadersForRequest(streamID, ordinaryHeaders, false)
+
+	} else if respFromServer {
+		if block.streamEnded {
+			// Trailers — filter grpc-* as trailer, rest as normal headers
+			pseudoNormal, pseudoTrailer := splitGrpcTrailerHeaders(pseudoHeaders)
+			ordinaryNormal, ordinaryTrailer := splitGrpcTrailerHeaders(ordinaryHeaders)
+
+			// Add "normal" parts as headers (still appears in trailers, but your system might need this distinction)
+			sic.AddHeadersForResponse(streamID, pseudoNormal, true, false)
+			sic.AddHeadersForResponse(streamID, ordinaryNormal, false, false)
+
+			// Add "grpc-" keys as actual trailers
+			sic.AddHeadersForResponse(streamID, pseudoTrailer, true, true)
+			sic.AddHeadersForResponse(streamID, ordinaryTrailer, false, true)
+
+			if _, ok := ordinaryTrailer["grpc-status"]; !ok {
+				logger.Warn("stream ended without a grpc-status trailer, recording as UNKNOWN",
+					zap.Uint32("streamID", streamID))
+			}
+			status, err := parseGrpcStatus(ordinaryTrailer)
+			if err != nil {
+				logger.Error("could not parse grpc status trailers", zap.Uint32("streamID", streamID), zap.Error(err))
+			} else {
+				sic.SetGrpcStatus(streamID, status)
+			}
+
+		} else {
+			// Just regular headers
+			sic.AddHeadersForResponse(streamID, pseudoHeaders, true, false)
+			sic.AddHeadersForResponse(streamID, ordinaryHeaders, false, false)
+		}
+	}
+	// The trailers frame has been received. The stream has been closed by the server.
+	// Capture the mock and clear the map, as the stream ID can be reused by client.
+	if respFromServer && block.streamEnded {
+		sic.PersistMockForStream(ctx, streamID, mocks)
+		sic.ResetStream(streamID)
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+//go:build linux
+
+package grpc
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxDecompressedGrpcMessageSize bounds how much a single gRPC message may
+// inflate to, so a corrupt or malicious gzip/deflate body captured off the
+// wire cannot exhaust memory during capture (a decompression bomb).
+const maxDecompressedGrpcMessageSize = 4 << 20 // 4 MiB
+
+// lengthPrefixedMessage is one decoded gRPC message. The 5-byte gRPC framing
+// header (1-byte compressed flag + 4-byte big-endian length) has already been
+// stripped, and Payload has been decompressed per Encoding when Compressed is
+// true, so mock matching and diffing can work on the semantic body.
+//
+// DecodeFailed is set when Compressed is true but decompression failed:
+// Payload then holds the raw, still-compressed bytes instead of the decoded
+// body, so callers must not persist it as a replayable mock body — replay
+// would run it back through compressGrpcMessage and compress it a second
+// time, producing a corrupt wire message.
+type lengthPrefixedMessage struct {
+	Compressed   bool
+	Encoding     string
+	Payload      []byte
+	DecodeFailed bool
+}
+
+// lpmReassembler reassembles gRPC Length-Prefixed-Messages out of a stream of
+// DATA frame payloads for a single stream. A single message may span multiple
+// DATA frames, and a single DATA frame may carry more than one message, so
+// fragments are buffered until a full header + body is available.
+type lpmReassembler struct {
+	buf []byte
+}
+
+// feed appends newly read DATA frame bytes and returns every message that is
+// now complete, decompressing each with the codec named by encoding (the
+// grpc-encoding header captured for this stream).
+func (r *lpmReassembler) feed(data []byte, encoding string) ([]lengthPrefixedMessage, error) {
+	r.buf = append(r.buf, data...)
+
+	var messages []lengthPrefixedMessage
+	var errs error
+	for {
+		const lpmHeaderSize = 5
+		if len(r.buf) < lpmHeaderSize {
+			break
+		}
+		compressed := r.buf[0] == 1
+		length := binary.BigEndian.Uint32(r.buf[1:lpmHeaderSize])
+		if length > maxDecompressedGrpcMessageSize {
+			// The declared length alone, before a single byte of body has
+			// been buffered, already exceeds what any message captured here
+			// is allowed to decompress to. identity-encoded messages never
+			// reach the decompression-bomb check at all, so this has to be
+			// enforced on the wire length too, not just the inflated size.
+			// The stream can't be resynced past a bogus length, so drop what
+			// was buffered for it.
+			r.buf = nil
+			return messages, errors.Join(errs, fmt.Errorf("grpc message length %d exceeds %d bytes", length, maxDecompressedGrpcMessageSize))
+		}
+		if uint32(len(r.buf)-lpmHeaderSize) < length {
+			break
+		}
+
+		payload := make([]byte, length)
+		copy(payload, r.buf[lpmHeaderSize:lpmHeaderSize+length])
+		r.buf = r.buf[lpmHeaderSize+length:]
+
+		// A message whose codec we don't support (or whose compressed body
+		// is truncated/corrupt) still happened on the wire: keep its raw,
+		// still-compressed bytes rather than dropping the message outright,
+		// and surface the failure via errs instead of losing it silently.
+		decoded := payload
+		decodeFailed := false
+		if compressed {
+			d, err := decompressGrpcMessage(payload, encoding)
+			if err != nil {
+				errs = errors.Join(errs, fmt.Errorf("could not decompress grpc message: %w", err))
+				decodeFailed = true
+			} else {
+				decoded = d
+			}
+		}
+
+		messages = append(messages, lengthPrefixedMessage{
+			Compressed:   compressed,
+			Encoding:     encoding,
+			Payload:      decoded,
+			DecodeFailed: decodeFailed,
+		})
+	}
+
+	return messages, errs
+}
+
+// decompressGrpcMessage inflates a gRPC message body per the codec named by a
+// grpc-encoding header.
+func decompressGrpcMessage(data []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", "identity":
+		return data, nil
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readAllCapped(r)
+	case "deflate":
+		r := flate.NewReader(bytes.NewReader(data))
+		defer r.Close()
+		return readAllCapped(r)
+	default:
+		return nil, fmt.Errorf("unsupported grpc-encoding %q", encoding)
+	}
+}
+
+// readAllCapped is io.ReadAll with a hard ceiling on how much it will
+// inflate, so a decompression bomb fails loudly instead of exhausting memory.
+func readAllCapped(r io.Reader) ([]byte, error) {
+	limited := io.LimitReader(r, maxDecompressedGrpcMessageSize+1)
+	data, err := io.ReadAll(limited)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxDecompressedGrpcMessageSize {
+		return nil, fmt.Errorf("decompressed grpc message exceeds %d bytes", maxDecompressedGrpcMessageSize)
+	}
+	return data, nil
+}
+
+// compressGrpcMessage is the inverse of decompressGrpcMessage, used when
+// replaying a recorded message that was captured compressed.
+func compressGrpcMessage(data []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch encoding {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "deflate":
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported grpc-encoding %q", encoding)
+	}
+}
+
+// encodeLengthPrefixedMessage builds the wire form of one gRPC message: the
+// 5-byte framing header followed by the (optionally compressed) body.
+func encodeLengthPrefixedMessage(data []byte, encoding string, compressed bool) ([]byte, error) {
+	body := data
+	var flag byte
+	if compressed && encoding != "" && encoding != "identity" {
+		compressedBody, err := compressGrpcMessage(data, encoding)
+		if err != nil {
+			return nil, fmt.Errorf("could not compress grpc message: %w", err)
+		}
+		body = compressedBody
+		flag = 1
+	}
+
+	const lpmHeaderSize = 5
+	wire := make([]byte, lpmHeaderSize+len(body))
+	wire[0] = flag
+	binary.BigEndian.PutUint32(wire[1:lpmHeaderSize], uint32(len(body)))
+	copy(wire[lpmHeaderSize:], body)
+	return wire, nil
+}
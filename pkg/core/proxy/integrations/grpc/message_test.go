@@ -0,0 +1,137 @@
+//go:build linux
+
+package grpc
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// lpmFrame builds the wire form of one Length-Prefixed-Message: the 5-byte
+// framing header (compressed flag + big-endian length) followed by body.
+func lpmFrame(compressed bool, body []byte) []byte {
+	frame := make([]byte, 5+len(body))
+	if compressed {
+		frame[0] = 1
+	}
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(body)))
+	copy(frame[5:], body)
+	return frame
+}
+
+// TestLpmReassembler_SplitAcrossFrames confirms a message whose header and
+// body arrive in separate DATA frame payloads is only returned once complete.
+func TestLpmReassembler_SplitAcrossFrames(t *testing.T) {
+	r := &lpmReassembler{}
+
+	frame := lpmFrame(false, []byte("hello"))
+
+	messages, err := r.feed(frame[:3], "")
+	if err != nil {
+		t.Fatalf("feed on a partial header returned an error: %v", err)
+	}
+	if len(messages) != 0 {
+		t.Fatalf("got %d messages from a partial header, want 0", len(messages))
+	}
+
+	messages, err = r.feed(frame[3:], "")
+	if err != nil {
+		t.Fatalf("feed on the remainder returned an error: %v", err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	if string(messages[0].Payload) != "hello" {
+		t.Errorf("Payload = %q, want %q", messages[0].Payload, "hello")
+	}
+}
+
+// TestLpmReassembler_MultipleMessagesInOneFrame confirms a single DATA frame
+// carrying more than one message yields all of them.
+func TestLpmReassembler_MultipleMessagesInOneFrame(t *testing.T) {
+	r := &lpmReassembler{}
+
+	data := append(lpmFrame(false, []byte("one")), lpmFrame(false, []byte("two"))...)
+	messages, err := r.feed(data, "")
+	if err != nil {
+		t.Fatalf("feed returned an error: %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(messages))
+	}
+	if string(messages[0].Payload) != "one" || string(messages[1].Payload) != "two" {
+		t.Errorf("got payloads %q, %q, want %q, %q", messages[0].Payload, messages[1].Payload, "one", "two")
+	}
+}
+
+// TestLpmReassembler_RejectsOversizeDeclaredLength ensures a declared length
+// beyond maxDecompressedGrpcMessageSize is rejected before any body bytes are
+// buffered, since identity-encoded messages never reach the decompression
+// ceiling in readAllCapped.
+func TestLpmReassembler_RejectsOversizeDeclaredLength(t *testing.T) {
+	r := &lpmReassembler{}
+
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:5], maxDecompressedGrpcMessageSize+1)
+
+	messages, err := r.feed(header, "identity")
+	if err == nil {
+		t.Fatal("expected feed to reject a declared length beyond maxDecompressedGrpcMessageSize, got nil error")
+	}
+	if len(messages) != 0 {
+		t.Fatalf("got %d messages from a rejected length, want 0", len(messages))
+	}
+}
+
+// TestLpmReassembler_SurfacesBadGzipWithoutDroppingMessage confirms a
+// corrupt compressed body still produces a message, carrying the raw
+// compressed bytes, alongside a reported error.
+func TestLpmReassembler_SurfacesBadGzipWithoutDroppingMessage(t *testing.T) {
+	r := &lpmReassembler{}
+
+	frame := lpmFrame(true, []byte("not actually gzip"))
+	messages, err := r.feed(frame, "gzip")
+	if err == nil {
+		t.Fatal("expected feed to report a gzip decompression error, got nil")
+	}
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	if string(messages[0].Payload) != "not actually gzip" {
+		t.Errorf("Payload = %q, want the raw compressed bytes preserved", messages[0].Payload)
+	}
+	if !messages[0].DecodeFailed {
+		t.Error("DecodeFailed = false, want true so callers know not to persist this message as a replayable mock")
+	}
+}
+
+// TestEncodeLengthPrefixedMessage_RoundTripsThroughDecompress confirms a
+// message re-encoded for replay with compression decodes back to the
+// original body via decompressGrpcMessage.
+func TestEncodeLengthPrefixedMessage_RoundTripsThroughDecompress(t *testing.T) {
+	wire, err := encodeLengthPrefixedMessage([]byte("round trip me"), "gzip", true)
+	if err != nil {
+		t.Fatalf("encodeLengthPrefixedMessage failed: %v", err)
+	}
+
+	r := &lpmReassembler{}
+	messages, err := r.feed(wire, "gzip")
+	if err != nil {
+		t.Fatalf("feed on the re-encoded message returned an error: %v", err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	if string(messages[0].Payload) != "round trip me" {
+		t.Errorf("Payload = %q, want %q", messages[0].Payload, "round trip me")
+	}
+}
+
+// TestDecompressGrpcMessage_RejectsUnsupportedEncoding confirms an
+// unrecognized grpc-encoding fails loudly instead of being treated as
+// identity.
+func TestDecompressGrpcMessage_RejectsUnsupportedEncoding(t *testing.T) {
+	if _, err := decompressGrpcMessage([]byte("data"), "snappy"); err == nil {
+		t.Fatal("expected decompressGrpcMessage to reject an unsupported encoding, got nil error")
+	}
+}
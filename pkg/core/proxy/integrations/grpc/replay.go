@@ -0,0 +1,291 @@
+//go:build linux
+
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.keploy.io/server/v2/pkg/models"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// serveFromMock replays a previously captured gRPC unary or server-streaming
+// RPC, mirroring the capture path in transferFrame in reverse: it consumes
+// the client's connection preface, then writes HEADERS (with :status,
+// content-type and grpc-encoding rebuilt via a fresh hpack.Encoder), one or
+// more DATA frames carrying the re-encoded length-prefixed message, and a
+// trailers-only HEADERS with grpc-status/grpc-message. The stream ID used
+// for the response is the one the replaying client actually chose on its
+// request HEADERS frame, read off conn below, which need not match the one
+// the mock was originally recorded on.
+func serveFromMock(ctx context.Context, logger *zap.Logger, conn net.Conn, mock *models.Mock) error {
+	// Every HTTP/2 client sends this 24-byte preface before any framed data;
+	// http2.Framer does not strip it, so it must be read and checked here the
+	// way http2.Server.ServeConn does, or the first ReadFrame call below
+	// tries to parse it as a frame header and fails.
+	preface := make([]byte, len(http2.ClientPreface))
+	if _, err := io.ReadFull(conn, preface); err != nil {
+		return fmt.Errorf("could not read client preface: %v", err)
+	}
+	if !bytes.Equal(preface, http2.ClientPreface) {
+		return fmt.Errorf("unexpected client preface: %q", preface)
+	}
+
+	framer := http2.NewFramer(conn, conn)
+	flow := newFlowController()
+	var writeMu sync.Mutex
+
+	if err := framer.WriteSettings(http2.Setting{ID: http2.SettingMaxFrameSize, Val: defaultMaxFrameSize}); err != nil {
+		return fmt.Errorf("could not write settings frame: %v", err)
+	}
+
+	// Drain the client's preface frames (SETTINGS, its ACK of ours, PING,
+	// WINDOW_UPDATE) until its request HEADERS arrive; nothing is replayed
+	// to the client until then, matching a real server's handshake order.
+	// The request HEADERS frame is also the only place the client's chosen
+	// stream ID is observable, so it is captured here rather than assumed.
+	var streamID uint32
+readLoop:
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return fmt.Errorf("could not read client frame: %v", err)
+		}
+
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if f.IsAck() {
+				continue
+			}
+			if err := f.ForeachSetting(func(setting http2.Setting) error {
+				if setting.ID == http2.SettingInitialWindowSize || setting.ID == http2.SettingMaxFrameSize {
+					flow.applySetting(setting.ID, setting.Val)
+				}
+				return nil
+			}); err != nil {
+				return fmt.Errorf("could not read client settings: %v", err)
+			}
+			if err := framer.WriteSettingsAck(); err != nil {
+				return fmt.Errorf("could not write settings ack: %v", err)
+			}
+		case *http2.WindowUpdateFrame:
+			flow.windowUpdate(f.StreamID, f.Increment)
+		case *http2.PingFrame:
+			if err := framer.WritePing(true, f.Data); err != nil {
+				return fmt.Errorf("could not write ping ack: %v", err)
+			}
+		case *http2.GoAwayFrame:
+			return nil
+		case *http2.HeadersFrame:
+			streamID = f.StreamID
+			break readLoop
+		}
+	}
+
+	// The response below may be several DATA frames for a server-streaming
+	// RPC, each possibly blocking in flow control until the client opens its
+	// window further. Nothing would ever deliver that WINDOW_UPDATE (or
+	// answer a PING) if reading stopped here, so a background goroutine
+	// keeps draining the client's frames for the rest of the call. ctx is
+	// wrapped so that goroutine's exit — whether from GOAWAY, a read error,
+	// or the deliberate read-deadline below once this call is done writing
+	// — also unblocks any write still waiting on flow control. writeMu
+	// serializes the two goroutines' frame writes, since a single framer
+	// supports only one writer at a time.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		defer cancel()
+		if err := drainClientFrames(ctx, framer, &writeMu, flow); err != nil {
+			logger.Debug("stopped draining client frames during grpc replay", zap.Error(err))
+		}
+	}()
+	defer func() {
+		_ = conn.SetReadDeadline(time.Now())
+		<-readerDone
+	}()
+
+	resp := mock.Spec.GrpcResp
+
+	var headerBlock bytes.Buffer
+	hEnc := hpack.NewEncoder(&headerBlock)
+	if err := writeReplayHeader(hEnc, ":status", "200"); err != nil {
+		return fmt.Errorf("could not encode headers: %v", err)
+	}
+	if err := writeReplayHeader(hEnc, "content-type", "application/grpc"); err != nil {
+		return fmt.Errorf("could not encode headers: %v", err)
+	}
+	encoding := resp.Headers.OrdinaryHeaders["grpc-encoding"]
+	if encoding != "" {
+		if err := writeReplayHeader(hEnc, "grpc-encoding", encoding); err != nil {
+			return fmt.Errorf("could not encode headers: %v", err)
+		}
+	}
+	for name, value := range resp.Headers.OrdinaryHeaders {
+		if name == "grpc-encoding" {
+			continue
+		}
+		if err := writeReplayHeader(hEnc, name, value); err != nil {
+			return fmt.Errorf("could not encode headers: %v", err)
+		}
+	}
+
+	writeMu.Lock()
+	err := framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: headerBlock.Bytes(),
+		EndHeaders:    true,
+	})
+	writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("could not write headers frame: %v", err)
+	}
+
+	wire, err := encodeLengthPrefixedMessage([]byte(resp.Body.DecodedData), encoding, resp.Body.CompressionFlag != 0)
+	if err != nil {
+		return fmt.Errorf("could not re-encode grpc response body: %v", err)
+	}
+	if err := writeReplayDataWithFlowControl(ctx, framer, &writeMu, flow, streamID, wire, false); err != nil {
+		return err
+	}
+
+	headerBlock.Reset()
+	status := resp.Status
+	if err := writeReplayHeader(hEnc, "grpc-status", strconv.Itoa(int(status.Code))); err != nil {
+		return fmt.Errorf("could not encode trailers: %v", err)
+	}
+	if status.Message != "" {
+		if err := writeReplayHeader(hEnc, "grpc-message", url.PathEscape(status.Message)); err != nil {
+			return fmt.Errorf("could not encode trailers: %v", err)
+		}
+	}
+	if len(status.DetailsBin) > 0 {
+		if err := writeReplayHeader(hEnc, "grpc-status-details-bin", base64.RawURLEncoding.EncodeToString(status.DetailsBin)); err != nil {
+			return fmt.Errorf("could not encode trailers: %v", err)
+		}
+	}
+
+	writeMu.Lock()
+	err = framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: headerBlock.Bytes(),
+		EndHeaders:    true,
+		EndStream:     true,
+	})
+	writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("could not write trailers frame: %v", err)
+	}
+
+	return nil
+}
+
+// drainClientFrames keeps servicing the client's SETTINGS, WINDOW_UPDATE and
+// PING frames for the remainder of a replayed call, so the response goroutine
+// in serveFromMock — which may be blocked in flow control waiting for exactly
+// one of those WINDOW_UPDATE frames — is not starved the way it would be if
+// reading stopped at the request HEADERS. It returns when the client sends
+// GOAWAY, ReadFrame errors (including the deliberate read deadline serveFromMock
+// sets once it is done writing), or ctx is cancelled.
+func drainClientFrames(ctx context.Context, framer *http2.Framer, writeMu *sync.Mutex, flow *flowController) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return err
+		}
+
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if f.IsAck() {
+				continue
+			}
+			if err := f.ForeachSetting(func(setting http2.Setting) error {
+				if setting.ID == http2.SettingInitialWindowSize || setting.ID == http2.SettingMaxFrameSize {
+					flow.applySetting(setting.ID, setting.Val)
+				}
+				return nil
+			}); err != nil {
+				return fmt.Errorf("could not read client settings: %v", err)
+			}
+			writeMu.Lock()
+			err = framer.WriteSettingsAck()
+			writeMu.Unlock()
+			if err != nil {
+				return fmt.Errorf("could not write settings ack: %v", err)
+			}
+		case *http2.WindowUpdateFrame:
+			flow.windowUpdate(f.StreamID, f.Increment)
+		case *http2.PingFrame:
+			writeMu.Lock()
+			err = framer.WritePing(true, f.Data)
+			writeMu.Unlock()
+			if err != nil {
+				return fmt.Errorf("could not write ping ack: %v", err)
+			}
+		case *http2.GoAwayFrame:
+			return nil
+		}
+	}
+}
+
+// writeReplayDataWithFlowControl mirrors writeDataWithFlowControl, additionally
+// guarding each DATA frame write with writeMu: unlike transferFrame, which
+// gives each direction its own framer, serveFromMock shares a single framer
+// between this call and the concurrent drainClientFrames reader.
+func writeReplayDataWithFlowControl(ctx context.Context, framer *http2.Framer, writeMu *sync.Mutex, fc *flowController, streamID uint32, payload []byte, endStream bool) error {
+	if len(payload) == 0 {
+		writeMu.Lock()
+		err := framer.WriteData(streamID, endStream, nil)
+		writeMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("could not write data frame: %v", err)
+		}
+		return nil
+	}
+
+	for len(payload) > 0 {
+		n, err := fc.acquire(ctx, streamID, len(payload))
+		if err != nil {
+			return fmt.Errorf("flow control wait interrupted: %w", err)
+		}
+		chunk := payload[:n]
+		payload = payload[n:]
+		writeMu.Lock()
+		err = framer.WriteData(streamID, endStream && len(payload) == 0, chunk)
+		writeMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("could not write data frame: %v", err)
+		}
+	}
+	return nil
+}
+
+func writeReplayHeader(enc *hpack.Encoder, name, value string) error {
+	return enc.WriteField(hpack.HeaderField{Name: name, Value: value})
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package grpc
+
+import "sync"
+
+// hpackTableSizeSync coordinates SETTINGS_HEADER_TABLE_SIZE exchanges between
+// the two proxied HTTP/2 directions of a single gRPC connection.
+//
+// A SETTINGS_HEADER_TABLE_SIZE sent by one peer bounds the hpack dynamic
+// table the *other* peer's encoder may use, and per RFC 7541 §4.2 the new
+// size must only be applied once that SETTINGS frame has actually been
+// ACKed. Since the ACK for a SETTINGS frame always travels in the opposite
+// direction from the frame it acknowledges, the two transferFrame goroutines
+// for a connection share one hpackTableSizeSync to hand the pending size
+// across that boundary.
+type hpackTableSizeSync struct {
+	mu sync.Mutex
+	// pendingForClientDecoder is the size the server advertised to the
+	// client; it is applied to the decoder used for client HEADERS frames
+	// once the client's ACK is observed flowing client->server.
+	pendingForClientDecoder *uint32
+	// pendingForServerDecoder is the size the client advertised to the
+	// server; it is applied to the decoder used for server HEADERS frames
+	// once the server's ACK is observed flowing server->client.
+	pendingForServerDecoder *uint32
+}
+
+// newHPACKTableSizeSync returns a negotiator shared by both directions of a
+// proxied connection.
+func newHPACKTableSizeSync() *hpackTableSizeSync {
+	return &hpackTableSizeSync{}
+}
+
+// propose records a SETTINGS_HEADER_TABLE_SIZE value observed in a
+// non-ACK SETTINGS frame sent by the client (sentByClient) or server.
+func (s *hpackTableSizeSync) propose(sentByClient bool, size uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sentByClient {
+		s.pendingForServerDecoder = &size
+	} else {
+		s.pendingForClientDecoder = &size
+	}
+}
+
+// takeAck consumes the pending size that is resolved by an ACK observed
+// while relaying frames in the reqFromClient direction, if any.
+func (s *hpackTableSizeSync) takeAck(reqFromClient bool) (uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reqFromClient {
+		if s.pendingForClientDecoder == nil {
+			return 0, false
+		}
+		size := *s.pendingForClientDecoder
+		s.pendingForClientDecoder = nil
+		return size, true
+	}
+	if s.pendingForServerDecoder == nil {
+		return 0, false
+	}
+	size := *s.pendingForServerDecoder
+	s.pendingForServerDecoder = nil
+	return size, true
+}
@@ -0,0 +1,123 @@
+//go:build linux
+
+package grpc
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// TestHPACKTableSizeSync_AppliesOnlyAfterAck exercises the cross-goroutine
+// handoff: a SETTINGS_HEADER_TABLE_SIZE proposed by one side must stay
+// pending until the matching ACK is observed flowing the other way, per RFC
+// 7541 §4.2.
+func TestHPACKTableSizeSync_AppliesOnlyAfterAck(t *testing.T) {
+	sync := newHPACKTableSizeSync()
+
+	// The client advertises a smaller table size; it must not be applied to
+	// the decoder used for server HEADERS frames until the server's ACK
+	// (observed server->client, i.e. reqFromClient=false) is seen.
+	sync.propose(true, 1024)
+
+	if _, ok := sync.takeAck(true); ok {
+		t.Fatal("takeAck(true) resolved a size proposed by the client before any ACK was observed")
+	}
+
+	size, ok := sync.takeAck(false)
+	if !ok {
+		t.Fatal("takeAck(false) did not resolve the client's pending size on the server's ACK")
+	}
+	if size != 1024 {
+		t.Fatalf("got size %d, want 1024", size)
+	}
+
+	if _, ok := sync.takeAck(false); ok {
+		t.Fatal("takeAck(false) resolved a second time for an already-consumed size")
+	}
+}
+
+// TestHPACKTableSizeSync_BothDirectionsIndependent verifies that a size
+// proposed by the server and one proposed by the client are tracked and
+// resolved independently.
+func TestHPACKTableSizeSync_BothDirectionsIndependent(t *testing.T) {
+	sync := newHPACKTableSizeSync()
+
+	sync.propose(true, 512)  // client -> applies to server decoder
+	sync.propose(false, 256) // server -> applies to client decoder
+
+	serverDecoderSize, ok := sync.takeAck(false)
+	if !ok || serverDecoderSize != 512 {
+		t.Fatalf("takeAck(false) = (%d, %v), want (512, true)", serverDecoderSize, ok)
+	}
+
+	clientDecoderSize, ok := sync.takeAck(true)
+	if !ok || clientDecoderSize != 256 {
+		t.Fatalf("takeAck(true) = (%d, %v), want (256, true)", clientDecoderSize, ok)
+	}
+}
+
+// TestExtractHeaders_SurvivesDynamicTableResize is a golden-HPACK-stream
+// test: it encodes a header block that opens with a dynamic-table-size
+// update (as a peer's HPACK encoder would emit right after its
+// SETTINGS_HEADER_TABLE_SIZE is ACKed), and checks that a decoder whose
+// allowed max was raised via SetAllowedMaxDynamicTableSize — exactly as
+// frame.go does once tableSizeSync.takeAck reports an ACKed size — decodes
+// it correctly instead of rejecting the resize.
+func TestExtractHeaders_SurvivesDynamicTableResize(t *testing.T) {
+	const negotiatedTableSize = 8192 // larger than KmaxDynamicTableSize
+
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	// Mirrors what a real encoder does once it learns the peer will accept
+	// a bigger dynamic table: raise its own limit, grow the table, and
+	// prefix the next header block with a dynamic table size update
+	// instruction.
+	enc.SetMaxDynamicTableSizeLimit(negotiatedTableSize)
+	enc.SetMaxDynamicTableSize(negotiatedTableSize)
+	if err := enc.WriteField(hpack.HeaderField{Name: ":method", Value: "POST"}); err != nil {
+		t.Fatalf("could not encode pseudo header: %v", err)
+	}
+	if err := enc.WriteField(hpack.HeaderField{Name: "grpc-encoding", Value: "gzip"}); err != nil {
+		t.Fatalf("could not encode header: %v", err)
+	}
+
+	decoder := hpack.NewDecoder(KmaxDynamicTableSize, nil)
+	// Without this, DecodeFull would reject the leading dynamic table size
+	// update as exceeding the decoder's allowed maximum.
+	decoder.SetAllowedMaxDynamicTableSize(negotiatedTableSize)
+
+	pseudoHeaders, ordinaryHeaders, err := extractHeaders(block.Bytes(), decoder)
+	if err != nil {
+		t.Fatalf("extractHeaders failed after a negotiated resize: %v", err)
+	}
+
+	if got, want := pseudoHeaders[":method"], "POST"; got != want {
+		t.Errorf("pseudoHeaders[:method] = %q, want %q", got, want)
+	}
+	if got, want := ordinaryHeaders["grpc-encoding"], "gzip"; got != want {
+		t.Errorf("ordinaryHeaders[grpc-encoding] = %q, want %q", got, want)
+	}
+}
+
+// TestExtractHeaders_RejectsResizeBeyondAllowedMax confirms the negative
+// case: if the allowed maximum were never raised, the same resize is
+// rejected rather than silently growing the table past what was agreed.
+func TestExtractHeaders_RejectsResizeBeyondAllowedMax(t *testing.T) {
+	const negotiatedTableSize = 8192
+
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	enc.SetMaxDynamicTableSizeLimit(negotiatedTableSize)
+	enc.SetMaxDynamicTableSize(negotiatedTableSize)
+	if err := enc.WriteField(hpack.HeaderField{Name: ":method", Value: "POST"}); err != nil {
+		t.Fatalf("could not encode pseudo header: %v", err)
+	}
+
+	decoder := hpack.NewDecoder(KmaxDynamicTableSize, nil)
+
+	if _, _, err := extractHeaders(block.Bytes(), decoder); err == nil {
+		t.Fatal("expected extractHeaders to reject a table-size update beyond the decoder's allowed max, got nil error")
+	}
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package grpc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+
+	"go.keploy.io/server/v2/pkg/models"
+)
+
+// grpcUnknownStatusCode is the code substituted for a stream that ends
+// without a grpc-status trailer, which real servers occasionally omit.
+const grpcUnknownStatusCode = 2 // codes.Unknown
+
+// parseGrpcStatus turns the grpc-* trailer map captured off a trailers-only
+// HEADERS frame into models.GrpcStatus: grpc-status as an int code,
+// grpc-message percent-decoded to UTF-8, and grpc-status-details-bin
+// base64-url decoded without padding.
+func parseGrpcStatus(trailers map[string]string) (models.GrpcStatus, error) {
+	status := models.GrpcStatus{Code: grpcUnknownStatusCode}
+
+	if raw, ok := trailers["grpc-status"]; ok {
+		var code int32
+		if _, err := fmt.Sscanf(raw, "%d", &code); err != nil {
+			return status, fmt.Errorf("could not parse grpc-status %q: %w", raw, err)
+		}
+		status.Code = code
+	}
+
+	if raw, ok := trailers["grpc-message"]; ok {
+		message, err := url.PathUnescape(raw)
+		if err != nil {
+			return status, fmt.Errorf("could not percent-decode grpc-message %q: %w", raw, err)
+		}
+		status.Message = message
+	}
+
+	if raw, ok := trailers["grpc-status-details-bin"]; ok {
+		details, err := base64.RawURLEncoding.DecodeString(raw)
+		if err != nil {
+			return status, fmt.Errorf("could not base64-decode grpc-status-details-bin: %w", err)
+		}
+		status.DetailsBin = details
+	}
+
+	return status, nil
+}
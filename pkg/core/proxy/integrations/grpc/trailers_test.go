@@ -0,0 +1,57 @@
+//go:build linux
+
+package grpc
+
+import "testing"
+
+// TestParseGrpcStatus_DefaultsToUnknownWithoutTrailer confirms a stream that
+// ends without a grpc-status trailer (which real servers occasionally omit)
+// is reported as codes.Unknown rather than erroring.
+func TestParseGrpcStatus_DefaultsToUnknownWithoutTrailer(t *testing.T) {
+	status, err := parseGrpcStatus(map[string]string{})
+	if err != nil {
+		t.Fatalf("parseGrpcStatus returned an error: %v", err)
+	}
+	if status.Code != grpcUnknownStatusCode {
+		t.Errorf("Code = %d, want %d", status.Code, grpcUnknownStatusCode)
+	}
+}
+
+// TestParseGrpcStatus_DecodesAllFields exercises the happy path across all
+// three grpc-* trailers, including percent-decoding grpc-message and
+// base64url-decoding grpc-status-details-bin.
+func TestParseGrpcStatus_DecodesAllFields(t *testing.T) {
+	status, err := parseGrpcStatus(map[string]string{
+		"grpc-status":             "5",
+		"grpc-message":            "not%20found",
+		"grpc-status-details-bin": "aGVsbG8",
+	})
+	if err != nil {
+		t.Fatalf("parseGrpcStatus returned an error: %v", err)
+	}
+	if status.Code != 5 {
+		t.Errorf("Code = %d, want 5", status.Code)
+	}
+	if status.Message != "not found" {
+		t.Errorf("Message = %q, want %q", status.Message, "not found")
+	}
+	if string(status.DetailsBin) != "hello" {
+		t.Errorf("DetailsBin = %q, want %q", status.DetailsBin, "hello")
+	}
+}
+
+// TestParseGrpcStatus_RejectsMalformedCode confirms a non-numeric
+// grpc-status fails loudly instead of silently falling back to Unknown.
+func TestParseGrpcStatus_RejectsMalformedCode(t *testing.T) {
+	if _, err := parseGrpcStatus(map[string]string{"grpc-status": "not-a-number"}); err == nil {
+		t.Fatal("expected parseGrpcStatus to reject a non-numeric grpc-status, got nil error")
+	}
+}
+
+// TestParseGrpcStatus_RejectsBadDetailsBin confirms invalid base64 in
+// grpc-status-details-bin is surfaced rather than silently dropped.
+func TestParseGrpcStatus_RejectsBadDetailsBin(t *testing.T) {
+	if _, err := parseGrpcStatus(map[string]string{"grpc-status-details-bin": "not valid base64!"}); err == nil {
+		t.Fatal("expected parseGrpcStatus to reject malformed grpc-status-details-bin, got nil error")
+	}
+}